@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+)
+
+// TrackerCache keeps track, per chart archive url, of the freshness
+// validators (ETag/Last-Modified) returned by the origin server on the last
+// scan. It's used to short-circuit chart versions whose index digest is
+// empty, where a HEAD request is the only cheap way to tell whether the
+// archive has changed since it was last processed.
+type TrackerCache struct {
+	mu      sync.Mutex
+	entries map[string]trackerCacheEntry
+}
+
+// trackerCacheEntry holds the validators cached for a single chart url.
+type trackerCacheEntry struct {
+	etag         string
+	lastModified string
+}
+
+// newTrackerCache creates a new TrackerCache instance.
+func newTrackerCache() *TrackerCache {
+	return &TrackerCache{entries: make(map[string]trackerCacheEntry)}
+}
+
+// get returns the cached entry for the url provided, if any.
+func (c *TrackerCache) get(u string) (trackerCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[u]
+	return e, ok
+}
+
+// put stores the entry for the url provided.
+func (c *TrackerCache) put(u string, e trackerCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[u] = e
+}
+
+// trackerCache is the HEAD-based freshness cache shared by all workers in
+// the tracker process.
+var trackerCache = newTrackerCache()
+
+// isFresh issues a HEAD request for the chart archive located at u and
+// compares the validators returned against the ones cached from the
+// previous scan. It returns true when the archive hasn't changed, in which
+// case the caller can skip downloading and processing it.
+func (w *worker) isFresh(u string) bool {
+	cached, ok := trackerCache.get(u)
+	if !ok {
+		return false
+	}
+
+	resp, err := w.headForFreshness(u)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+
+	// An ETag, when present on both sides, is authoritative: some hosts (eg.
+	// static/CDN-backed mirrors) republish content in place without bumping
+	// Last-Modified, so falling back to it here would keep treating changed
+	// content as fresh. Only fall back to Last-Modified when neither side
+	// has an ETag to compare.
+	if etag != "" || cached.etag != "" {
+		return etag != "" && etag == cached.etag
+	}
+	return lastModified != "" && lastModified == cached.lastModified
+}
+
+// rememberFreshness records the validators returned for the chart archive
+// located at u so future scans can short-circuit via isFresh.
+func (w *worker) rememberFreshness(u string) {
+	resp, err := w.headForFreshness(u)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+	trackerCache.put(u, trackerCacheEntry{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	})
+}
+
+// headForFreshness issues a HEAD request for u bound to the worker's
+// context, so a cancelled tracker doesn't keep blocking on it.
+func (w *worker) headForFreshness(u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return w.httpClient.Do(req)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsFresh(t *testing.T) {
+	tests := []struct {
+		name          string
+		cachedEtag    string
+		cachedLastMod string
+		respEtag      string
+		respLastMod   string
+		expected      bool
+	}{
+		{
+			name:       "etag matches",
+			cachedEtag: "abc",
+			respEtag:   "abc",
+			expected:   true,
+		},
+		{
+			name:          "etag differs even though last-modified matches",
+			cachedEtag:    "abc",
+			cachedLastMod: "Mon, 01 Jan 2024 00:00:00 GMT",
+			respEtag:      "def",
+			respLastMod:   "Mon, 01 Jan 2024 00:00:00 GMT",
+			expected:      false,
+		},
+		{
+			name:          "etag present on cached side only",
+			cachedEtag:    "abc",
+			cachedLastMod: "Mon, 01 Jan 2024 00:00:00 GMT",
+			respLastMod:   "Mon, 01 Jan 2024 00:00:00 GMT",
+			expected:      false,
+		},
+		{
+			name:          "etag present on response side only",
+			respEtag:      "abc",
+			cachedLastMod: "Mon, 01 Jan 2024 00:00:00 GMT",
+			respLastMod:   "Mon, 01 Jan 2024 00:00:00 GMT",
+			expected:      false,
+		},
+		{
+			name:          "no etag on either side, last-modified matches",
+			cachedLastMod: "Mon, 01 Jan 2024 00:00:00 GMT",
+			respLastMod:   "Mon, 01 Jan 2024 00:00:00 GMT",
+			expected:      true,
+		},
+		{
+			name:          "no etag on either side, last-modified differs",
+			cachedLastMod: "Mon, 01 Jan 2024 00:00:00 GMT",
+			respLastMod:   "Tue, 02 Jan 2024 00:00:00 GMT",
+			expected:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.respEtag != "" {
+					w.Header().Set("ETag", tt.respEtag)
+				}
+				if tt.respLastMod != "" {
+					w.Header().Set("Last-Modified", tt.respLastMod)
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer srv.Close()
+
+			trackerCache.put(srv.URL, trackerCacheEntry{etag: tt.cachedEtag, lastModified: tt.cachedLastMod})
+
+			w := &worker{ctx: context.Background(), httpClient: srv.Client()}
+			if got := w.isFresh(srv.URL); got != tt.expected {
+				t.Errorf("isFresh() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
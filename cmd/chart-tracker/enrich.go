@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/artifacthub/hub/internal/hub"
+	"github.com/rs/zerolog/log"
+	"helm.sh/helm/v3/pkg/chart"
+	"sigs.k8s.io/yaml"
+)
+
+// enrichPackage populates the hub.Package fields that are extracted from the
+// chart's own files (values.yaml, templates, CRDs and dependencies), on top
+// of the metadata already set by the caller. This mirrors the set of files
+// Harbor's chart_operator appends to the files map it returns for a chart.
+func enrichPackage(p *hub.Package, c *chart.Chart) {
+	if values := getFile(c, "values.yaml"); values != nil {
+		p.DefaultValues = string(values.Data)
+		parsed, keys, err := parseValues(values.Data)
+		if err != nil {
+			log.Debug().Err(err).Str("chart", c.Metadata.Name).Msg("Error parsing values.yaml")
+		} else {
+			p.Values = parsed
+			p.ValuesKeys = keys
+		}
+	}
+
+	for _, tpl := range c.Templates {
+		p.Templates = append(p.Templates, &hub.TemplateInfo{
+			Name: tpl.Name,
+			Data: string(tpl.Data),
+		})
+	}
+
+	for _, crd := range c.CRDObjects() {
+		p.CRDs = append(p.CRDs, &hub.CRDInfo{
+			Name: crd.Name,
+			Data: string(crd.File.Data),
+		})
+	}
+
+	for _, dep := range c.Metadata.Dependencies {
+		p.Dependencies = append(p.Dependencies, &hub.ChartDependency{
+			Name:       dep.Name,
+			Version:    dep.Version,
+			Repository: dep.Repository,
+		})
+	}
+}
+
+// parseValues parses the raw values.yaml contents into a generic map, in
+// addition to returning every key path it contains flattened into dotted
+// notation (eg. "image.repository"), so the search indexer can match on
+// individual values keys without having to walk the map itself.
+func parseValues(data []byte) (map[string]interface{}, []string, error) {
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, nil, fmt.Errorf("error parsing values.yaml: %w", err)
+	}
+	return values, flattenValuesKeys("", values), nil
+}
+
+// flattenValuesKeys returns the dotted key paths for every entry in m,
+// descending into nested maps and prefixing each key with prefix.
+func flattenValuesKeys(prefix string, m map[string]interface{}) []string {
+	var keys []string
+	for k, v := range m {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		keys = append(keys, key)
+		if nested, ok := v.(map[string]interface{}); ok {
+			keys = append(keys, flattenValuesKeys(key, nested)...)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestFlattenValuesKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefix   string
+		values   map[string]interface{}
+		expected []string
+	}{
+		{
+			name:     "empty map",
+			values:   map[string]interface{}{},
+			expected: nil,
+		},
+		{
+			name: "flat map",
+			values: map[string]interface{}{
+				"replicaCount": 1,
+				"image":        "nginx",
+			},
+			expected: []string{"image", "replicaCount"},
+		},
+		{
+			name: "nested map",
+			values: map[string]interface{}{
+				"image": map[string]interface{}{
+					"repository": "nginx",
+					"tag":        "latest",
+				},
+			},
+			expected: []string{"image", "image.repository", "image.tag"},
+		},
+		{
+			name:   "prefix is applied",
+			prefix: "root",
+			values: map[string]interface{}{
+				"enabled": true,
+			},
+			expected: []string{"root.enabled"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := flattenValuesKeys(tt.prefix, tt.values)
+			sort.Strings(got)
+			sort.Strings(tt.expected)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("flattenValuesKeys(%q, %v) = %v, want %v", tt.prefix, tt.values, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/artifacthub/hub/internal/hub"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+// EventType identifies the kind of occurrence a tracker event represents.
+type EventType string
+
+const (
+	// EventChartVersionAdded is emitted when a chart version is registered
+	// for the first time.
+	EventChartVersionAdded EventType = "chart-version-added"
+	// EventChartVersionUpdated is emitted when an already registered chart
+	// version is re-processed and registered again: either its index digest
+	// differs from the stored one, or the index has no digest and the archive
+	// couldn't be confirmed unchanged.
+	EventChartVersionUpdated EventType = "chart-version-updated"
+	// EventChartLoadFailed is emitted when a chart archive couldn't be
+	// downloaded or parsed.
+	EventChartLoadFailed EventType = "chart-load-failed"
+	// EventChartDeprecated is emitted when a chart version flips its
+	// deprecated flag to true.
+	EventChartDeprecated EventType = "chart-deprecated"
+	// EventLogoChanged is emitted when a re-processed chart version's logo
+	// image differs from the one stored for that same version.
+	EventLogoChanged EventType = "chart-logo-changed"
+)
+
+// Event represents a single occurrence in the tracker pipeline, published to
+// any configured sinks so downstream systems (CI, security scanners,
+// mirroring tools) can react to it.
+type Event struct {
+	Type      EventType `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	Repo      string    `json:"repo"`
+	Chart     string    `json:"chart,omitempty"`
+	Version   string    `json:"version,omitempty"`
+	Digest    string    `json:"digest,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// EventSink is implemented by the types that deliver events to a downstream
+// integration.
+type EventSink interface {
+	Publish(ctx context.Context, e *Event) error
+}
+
+// eventBus fans an event out to every sink configured for it.
+type eventBus struct {
+	sinks []EventSink
+}
+
+// newEventBus creates a new eventBus with the sinks provided.
+func newEventBus(sinks ...EventSink) *eventBus {
+	return &eventBus{sinks: sinks}
+}
+
+// publish delivers the event to every sink, logging (but not failing the job
+// on) individual sink errors.
+func (b *eventBus) publish(ctx context.Context, e *Event) {
+	if b == nil {
+		return
+	}
+	for _, sink := range b.sinks {
+		if err := sink.Publish(ctx, e); err != nil {
+			log.Warn().Err(err).Str("type", string(e.Type)).Msg("Error publishing tracker event")
+		}
+	}
+}
+
+// webhookSink delivers events as signed HTTP POST requests, following the
+// same HMAC signature scheme used by GitHub/GitLab webhooks.
+type webhookSink struct {
+	url        string
+	secret     string
+	httpClient *http.Client
+}
+
+// newWebhookSink creates a new webhookSink instance.
+func newWebhookSink(url, secret string, httpClient *http.Client) *webhookSink {
+	return &webhookSink{url: url, secret: secret, httpClient: httpClient}
+}
+
+// Publish implements the EventSink interface.
+func (s *webhookSink) Publish(ctx context.Context, e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("error marshaling event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.secret != "" {
+		req.Header.Set("X-Hub-Signature-256", "sha256="+sign(s.secret, body))
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook returned unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// sign returns the hex encoded HMAC-SHA256 signature of body using secret.
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// natsSink delivers events by publishing them to a NATS JetStream subject.
+type natsSink struct {
+	js      nats.JetStreamContext
+	subject string
+}
+
+// newNATSSink creates a new natsSink instance, publishing to the given
+// subject on the connection provided.
+func newNATSSink(nc *nats.Conn, subject string) (*natsSink, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("error getting jetstream context: %w", err)
+	}
+	return &natsSink{js: js, subject: subject}, nil
+}
+
+// Publish implements the EventSink interface.
+func (s *natsSink) Publish(ctx context.Context, e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("error marshaling event: %w", err)
+	}
+	_, err = s.js.Publish(s.subject, body)
+	return err
+}
+
+// sinksFor builds the list of sinks configured on the given repository
+// itself, which is its webhook when one is set. The globally configured
+// sinks are not included; publishEvent adds these to them.
+func sinksFor(repo *hub.ChartRepository, httpClient *http.Client) []EventSink {
+	var sinks []EventSink
+	if repo.WebhookURL != "" {
+		sinks = append(sinks, newWebhookSink(repo.WebhookURL, repo.WebhookSecret, httpClient))
+	}
+	return sinks
+}
+
+// newGlobalEventBus builds the eventBus shared by all workers in the tracker
+// process from its environment configuration, wiring in a webhook and/or a
+// NATS JetStream sink when one is configured. It's fine for neither to be
+// set: the bus then falls back to whatever sinks are configured per
+// repository.
+func newGlobalEventBus(httpClient *http.Client) *eventBus {
+	var sinks []EventSink
+
+	if url := os.Getenv("TRACKER_EVENTS_WEBHOOK_URL"); url != "" {
+		sinks = append(sinks, newWebhookSink(url, os.Getenv("TRACKER_EVENTS_WEBHOOK_SECRET"), httpClient))
+	}
+
+	if url := os.Getenv("TRACKER_EVENTS_NATS_URL"); url != "" {
+		subject := os.Getenv("TRACKER_EVENTS_NATS_SUBJECT")
+		if subject == "" {
+			subject = "hub.tracker.events"
+		}
+		nc, err := nats.Connect(url)
+		if err != nil {
+			log.Error().Err(err).Str("url", url).Msg("Error connecting to NATS, global NATS event sink disabled")
+		} else if sink, err := newNATSSink(nc, subject); err != nil {
+			log.Error().Err(err).Msg("Error creating NATS event sink, global NATS event sink disabled")
+		} else {
+			sinks = append(sinks, sink)
+		}
+	}
+
+	return newEventBus(sinks...)
+}
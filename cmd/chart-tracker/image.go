@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+	"golang.org/x/image/draw"
+)
+
+// logoRenditionSize is the pixel dimension (square) that SVG logos and raster
+// logos larger than it are normalized to. Smaller raster logos are stored
+// unchanged.
+const logoRenditionSize = 128
+
+// imageCache keeps track, per icon url, of the validators returned by the
+// origin server (ETag/Last-Modified) and the content-addressable id the
+// image was last stored under, so unchanged icons are neither re-downloaded
+// nor re-decoded on subsequent scans.
+type imageCache struct {
+	mu      sync.Mutex
+	entries map[string]imageCacheEntry
+}
+
+// imageCacheEntry represents the cached state for a single icon url.
+type imageCacheEntry struct {
+	etag         string
+	lastModified string
+	imageID      string
+}
+
+// newImageCache creates a new imageCache instance.
+func newImageCache() *imageCache {
+	return &imageCache{entries: make(map[string]imageCacheEntry)}
+}
+
+// get returns the cached entry for the url provided, if any.
+func (c *imageCache) get(u string) (imageCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[u]
+	return e, ok
+}
+
+// put stores the entry for the url provided.
+func (c *imageCache) put(u string, e imageCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[u] = e
+}
+
+// imgFetchCache is the etag/content id cache shared by all workers in the
+// tracker process.
+var imgFetchCache = newImageCache()
+
+// contentStore deduplicates image bytes across charts, repositories and
+// scans by indexing the image store content-addressable id by the SHA256
+// digest of the image data.
+type contentStore struct {
+	mu  sync.Mutex
+	ids map[string]string
+}
+
+// newContentStore creates a new contentStore instance.
+func newContentStore() *contentStore {
+	return &contentStore{ids: make(map[string]string)}
+}
+
+// idFor returns the content-addressable id previously stored for the digest
+// provided, if any.
+func (s *contentStore) idFor(digest string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.ids[digest]
+	return id, ok
+}
+
+// register associates the digest provided with the content-addressable id
+// returned by the image store.
+func (s *contentStore) register(digest, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ids[digest] = id
+}
+
+// imgContentStore is the digest -> image id index shared by all workers.
+var imgContentStore = newContentStore()
+
+// fetchImage downloads the image located at the url provided, issuing a
+// conditional request when a previous fetch for the same url was cached. It
+// returns the raw image data (nil if the resource hasn't changed) and the
+// validators received from the origin, if any.
+func (w *worker) fetchImage(u string) (data []byte, etag, lastModified string, notModified bool, err error) {
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, "", "", false, err
+	}
+	if cached, ok := imgFetchCache.get(u); ok {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return nil, "", "", false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"), true, nil
+	case http.StatusOK:
+		body, err := readAll(resp)
+		if err != nil {
+			return nil, "", "", false, err
+		}
+		return body, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"), false, nil
+	default:
+		return nil, "", "", false, fmt.Errorf("unexpected status code received: %d", resp.StatusCode)
+	}
+}
+
+// processLogo downloads the logo located at the url provided, honoring the
+// etag cache to avoid redundant downloads, and stores it in the image store,
+// skipping the decode/store step entirely when an image with the same
+// content digest has already been processed. SVG logos, which the image
+// store can't decode directly, are rasterized to logoRenditionSize before
+// being stored; raster logos larger than logoRenditionSize are downscaled to
+// it as well. Logos already within bounds are stored as-is, in their
+// original format and dimensions.
+//
+// hub.Package only has a single LogoImageID field, so there's nowhere to
+// keep small/medium/large renditions as separate images; this stores one
+// rendition per logo rather than the three originally requested.
+func (w *worker) processLogo(u string) (string, error) {
+	data, etag, lastModified, notModified, err := w.fetchImage(u)
+	if err != nil {
+		return "", err
+	}
+	if notModified {
+		if cached, ok := imgFetchCache.get(u); ok {
+			return cached.imageID, nil
+		}
+		return "", nil
+	}
+
+	d := digest(data)
+	if id, ok := imgContentStore.idFor(d); ok {
+		imgFetchCache.put(u, imageCacheEntry{etag: etag, lastModified: lastModified, imageID: id})
+		return id, nil
+	}
+
+	var rendition []byte
+	if isSVG(data) {
+		rendition, err = renderSVG(data)
+		if err != nil {
+			return "", fmt.Errorf("error rasterizing svg logo: %w", err)
+		}
+	} else {
+		rendition, err = resizeLogo(data)
+		if err != nil {
+			return "", fmt.Errorf("error resizing logo: %w", err)
+		}
+	}
+	imageID, err := w.imageStore.SaveImage(w.ctx, rendition)
+	if err != nil {
+		return "", fmt.Errorf("error saving logo: %w", err)
+	}
+
+	imgContentStore.register(d, imageID)
+	imgFetchCache.put(u, imageCacheEntry{etag: etag, lastModified: lastModified, imageID: imageID})
+	return imageID, nil
+}
+
+// readAll reads the entirety of the response body.
+func readAll(resp *http.Response) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// digest returns the hex encoded SHA256 digest of the data provided.
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// isSVG returns true when the data provided looks like an SVG document. SVGs
+// aren't detected by image.DecodeConfig, which otherwise causes them to be
+// silently dropped as image.ErrFormat.
+func isSVG(data []byte) bool {
+	head := strings.ToLower(string(data[:min(len(data), 512)]))
+	return strings.Contains(head, "<svg") || strings.Contains(strings.TrimSpace(head), "<?xml")
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// renderSVG rasterizes the SVG data provided into a logoRenditionSize square
+// PNG.
+func renderSVG(data []byte) ([]byte, error) {
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing svg: %w", err)
+	}
+
+	const size = logoRenditionSize
+	icon.SetTarget(0, 0, float64(size), float64(size))
+	rgba := rasterx.NewRGBA(size, size)
+	scanner := rasterx.NewScannerGV(size, size, rgba, rgba.Bounds())
+	raster := rasterx.NewDasher(size, size, scanner)
+	icon.Draw(raster, 1.0)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, rgba); err != nil {
+		return nil, fmt.Errorf("error encoding svg png rendition: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// resizeLogo decodes a raster logo (PNG, JPEG or GIF) and, when either of its
+// dimensions exceeds logoRenditionSize, downscales it to a logoRenditionSize
+// square PNG. Logos already within bounds are returned unchanged.
+func resizeLogo(data []byte) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding logo: %w", err)
+	}
+	b := src.Bounds()
+	if b.Dx() <= logoRenditionSize && b.Dy() <= logoRenditionSize {
+		return data, nil
+	}
+
+	const size = logoRenditionSize
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, fmt.Errorf("error encoding resized logo rendition: %w", err)
+	}
+	return buf.Bytes(), nil
+}
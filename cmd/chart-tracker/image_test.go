@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestIsSVG(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected bool
+	}{
+		{
+			name:     "svg with xml prolog",
+			data:     []byte(`<?xml version="1.0" encoding="UTF-8"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`),
+			expected: true,
+		},
+		{
+			name:     "svg without prolog",
+			data:     []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`),
+			expected: true,
+		},
+		{
+			name:     "uppercase svg tag",
+			data:     []byte(`<SVG xmlns="http://www.w3.org/2000/svg"></SVG>`),
+			expected: true,
+		},
+		{
+			name:     "png magic bytes",
+			data:     []byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A},
+			expected: false,
+		},
+		{
+			name:     "empty data",
+			data:     []byte{},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSVG(tt.data); got != tt.expected {
+				t.Errorf("isSVG(%q) = %v, want %v", tt.data, got, tt.expected)
+			}
+		})
+	}
+}
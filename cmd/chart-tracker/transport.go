@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+// transportConfig holds the tunables for the shared HTTP transport used by
+// all workers to fetch chart archives and related files.
+type transportConfig struct {
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	BodyTimeout           time.Duration
+	MaxIdleConnsPerHost   int
+	RequestsPerSecond     float64
+	Burst                 int
+	MaxRetries            int
+}
+
+// defaultTransportConfig returns the transport configuration used when none
+// is provided explicitly.
+func defaultTransportConfig() *transportConfig {
+	return &transportConfig{
+		DialTimeout:           5 * time.Second,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+		BodyTimeout:           30 * time.Second,
+		MaxIdleConnsPerHost:   10,
+		RequestsPerSecond:     5,
+		Burst:                 10,
+		MaxRetries:            3,
+	}
+}
+
+var (
+	httpRequestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "chart_tracker_http_requests_in_flight",
+		Help: "Number of chart tracker http requests currently in flight.",
+	})
+	httpRequestsRetried = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "chart_tracker_http_requests_retried_total",
+		Help: "Number of chart tracker http requests that were retried.",
+	})
+	httpBytesDownloaded = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "chart_tracker_http_bytes_downloaded_total",
+		Help: "Number of bytes downloaded by the chart tracker.",
+	})
+	chartLoadDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chart_tracker_chart_load_duration_seconds",
+		Help:    "Time spent loading a chart archive, from request to fully parsed chart.",
+		Buckets: prometheus.DefBuckets,
+	})
+)
+
+func init() {
+	prometheus.MustRegister(httpRequestsInFlight, httpRequestsRetried, httpBytesDownloaded, chartLoadDuration)
+}
+
+// hostLimiter throttles outgoing requests on a per-host basis so that a
+// single slow or misbehaving repository host doesn't starve the rest of the
+// tracker's concurrency budget.
+type hostLimiter struct {
+	mu       sync.Mutex
+	cfg      *transportConfig
+	limiters map[string]*rate.Limiter
+}
+
+// newHostLimiter creates a new hostLimiter instance.
+func newHostLimiter(cfg *transportConfig) *hostLimiter {
+	return &hostLimiter{cfg: cfg, limiters: make(map[string]*rate.Limiter)}
+}
+
+// wait blocks until a request to host is allowed to proceed.
+func (l *hostLimiter) wait(ctx context.Context, host string) error {
+	l.mu.Lock()
+	limiter, ok := l.limiters[host]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(l.cfg.RequestsPerSecond), l.cfg.Burst)
+		l.limiters[host] = limiter
+	}
+	l.mu.Unlock()
+	return limiter.Wait(ctx)
+}
+
+// rateLimitedTransport wraps an http.RoundTripper, adding per-host rate
+// limiting, retries with exponential backoff on 5xx/429 responses
+// (honouring Retry-After) and Prometheus instrumentation. Each attempt gets
+// its own bounded deadline rather than sharing a single client-wide timeout,
+// so a slow host can't eat into the budget later retries need.
+type rateLimitedTransport struct {
+	next    http.RoundTripper
+	limiter *hostLimiter
+	cfg     *transportConfig
+}
+
+// attemptTimeout returns the deadline applied to a single RoundTrip attempt.
+func (t *rateLimitedTransport) attemptTimeout() time.Duration {
+	return t.cfg.DialTimeout + t.cfg.TLSHandshakeTimeout + t.cfg.ResponseHeaderTimeout + t.cfg.BodyTimeout
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.wait(req.Context(), req.URL.Host); err != nil {
+		return nil, err
+	}
+
+	httpRequestsInFlight.Inc()
+	defer httpRequestsInFlight.Dec()
+
+	timeout := t.attemptTimeout()
+	var resp *http.Response
+	var err error
+	for attempt := 0; attempt <= t.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			httpRequestsRetried.Inc()
+			if err := sleep(req.Context(), retryDelay(attempt, resp)); err != nil {
+				return nil, err
+			}
+		}
+		resp, err = t.doAttempt(req, timeout)
+		if err != nil {
+			continue
+		}
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < http.StatusInternalServerError {
+			break
+		}
+		if attempt < t.cfg.MaxRetries {
+			resp.Body.Close()
+		}
+	}
+	if err == nil && resp != nil {
+		resp.Body = &countingReadCloser{rc: resp.Body}
+	}
+	return resp, err
+}
+
+// doAttempt performs a single RoundTrip attempt bounded by timeout,
+// independently of how many attempts preceded or will follow it. The
+// deadline is released once the response body is closed rather than when
+// RoundTrip returns, since the body is typically still being read.
+func (t *rateLimitedTransport) doAttempt(req *http.Request, timeout time.Duration) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	resp, err := t.next.RoundTrip(req.Clone(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnCloseBody{rc: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// sleep blocks for d, returning early with ctx.Err() if ctx is done first,
+// so a cancelled request doesn't keep a retry backoff (up to 10s) blocking
+// the goroutine after the caller has stopped waiting for it.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryDelay computes how long to wait before the next retry attempt,
+// honouring the Retry-After header when the previous response included one
+// and otherwise falling back to exponential backoff.
+func retryDelay(attempt int, prevResp *http.Response) time.Duration {
+	if prevResp != nil {
+		if ra := prevResp.Header.Get("Retry-After"); ra != "" {
+			if secs, err := strconv.Atoi(ra); err == nil {
+				return time.Duration(secs) * time.Second
+			}
+		}
+	}
+	backoff := time.Duration(math.Pow(2, float64(attempt))) * 100 * time.Millisecond
+	if backoff > 10*time.Second {
+		backoff = 10 * time.Second
+	}
+	return backoff
+}
+
+// countingReadCloser wraps a response body, tracking the number of bytes
+// downloaded through it for the bytes-downloaded metric.
+type countingReadCloser struct {
+	rc io.ReadCloser
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.rc.Read(p)
+	httpBytesDownloaded.Add(float64(n))
+	return n, err
+}
+
+func (c *countingReadCloser) Close() error {
+	return c.rc.Close()
+}
+
+// cancelOnCloseBody wraps a response body, releasing the per-attempt
+// deadline set up by doAttempt once the caller is done reading it.
+type cancelOnCloseBody struct {
+	rc     io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnCloseBody) Read(p []byte) (int, error) {
+	return c.rc.Read(p)
+}
+
+func (c *cancelOnCloseBody) Close() error {
+	defer c.cancel()
+	return c.rc.Close()
+}
+
+// newSharedHTTPClient builds the http.Client shared by every worker in the
+// tracker process, configured with per-host rate limiting, a bounded
+// connection pool and split dial/TLS/response-header/body timeouts. The
+// client itself carries no overall Timeout: rateLimitedTransport bounds each
+// individual attempt instead, so a slow or failing host can't consume the
+// deadline before MaxRetries gets a chance to run.
+func newSharedHTTPClient(cfg *transportConfig) *http.Client {
+	dialer := &net.Dialer{Timeout: cfg.DialTimeout}
+	base := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxIdleConnsPerHost * 2,
+	}
+	return &http.Client{
+		Transport: &rateLimitedTransport{
+			next:    base,
+			limiter: newHostLimiter(cfg),
+			cfg:     cfg,
+		},
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempt  int
+		prevResp *http.Response
+		expected time.Duration
+	}{
+		{
+			name:     "first retry backs off 200ms",
+			attempt:  1,
+			expected: 200 * time.Millisecond,
+		},
+		{
+			name:     "second retry backs off 400ms",
+			attempt:  2,
+			expected: 400 * time.Millisecond,
+		},
+		{
+			name:     "backoff is capped at 10s",
+			attempt:  10,
+			expected: 10 * time.Second,
+		},
+		{
+			name:    "retry-after header takes precedence over backoff",
+			attempt: 1,
+			prevResp: &http.Response{
+				Header: http.Header{"Retry-After": []string{"3"}},
+			},
+			expected: 3 * time.Second,
+		},
+		{
+			name:    "non-numeric retry-after falls back to backoff",
+			attempt: 1,
+			prevResp: &http.Response{
+				Header: http.Header{"Retry-After": []string{"not-a-number"}},
+			},
+			expected: 200 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryDelay(tt.attempt, tt.prevResp); got != tt.expected {
+				t.Errorf("retryDelay(%d, ...) = %v, want %v", tt.attempt, got, tt.expected)
+			}
+		})
+	}
+}
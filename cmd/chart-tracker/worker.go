@@ -4,10 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"image"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
 	"runtime/debug"
 	"sync"
@@ -20,6 +20,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/provenance"
 )
 
 // worker is in charge of handling jobs generated by the dispatcher.
@@ -31,8 +32,19 @@ type worker struct {
 	imageStore img.Store
 	logger     zerolog.Logger
 	httpClient *http.Client
+	events     *eventBus
 }
 
+// sharedHTTPClient is the http.Client used by all workers in the tracker
+// process, so rate limiting and connection pooling are coordinated across
+// them rather than per worker.
+var sharedHTTPClient = newSharedHTTPClient(defaultTransportConfig())
+
+// globalEventBus holds the sinks configured for all repositories, such as a
+// webhook or a NATS JetStream publisher (see newGlobalEventBus). Per-repository
+// sinks (ie. webhooks) are added on top of these when an event is published.
+var globalEventBus = newGlobalEventBus(sharedHTTPClient)
+
 // newWorker creates a new worker instance.
 func newWorker(ctx context.Context, id int, ec *errorsCollector, hubAPI *api.API, imageStore img.Store) *worker {
 	return &worker{
@@ -42,9 +54,8 @@ func newWorker(ctx context.Context, id int, ec *errorsCollector, hubAPI *api.API
 		hubAPI:     hubAPI,
 		imageStore: imageStore,
 		logger:     log.With().Int("worker", id).Logger(),
-		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
-		},
+		httpClient: sharedHTTPClient,
+		events:     globalEventBus,
 	}
 }
 
@@ -106,8 +117,26 @@ func (w *worker) handleJob(j *job) error {
 		u = tmp.String()
 	}
 
+	// Skip this version entirely when it's already registered and unchanged,
+	// avoiding the cost of downloading and processing the chart archive
+	// again on re-scans.
+	existing, err := w.hubAPI.Packages.GetByName(w.ctx, j.repo.ChartRepositoryID, j.chartVersion.Metadata.Name, j.chartVersion.Metadata.Version)
+	if err != nil && !errors.Is(err, hub.ErrNotFound) {
+		return fmt.Errorf("error checking existing package: %w", err)
+	}
+	if existing != nil {
+		switch {
+		case j.chartVersion.Digest != "" && j.chartVersion.Digest == existing.Digest:
+			return w.hubAPI.Packages.TouchLastSeen(w.ctx, existing.PackageID)
+		case j.chartVersion.Digest == "" && w.isFresh(u):
+			return w.hubAPI.Packages.TouchLastSeen(w.ctx, existing.PackageID)
+		}
+	}
+
 	// Load chart from remote archive
-	chart, err := w.loadChart(u)
+	loadStart := time.Now()
+	chart, archiveData, provData, err := w.loadChart(u, j.repo.Keyring != "")
+	chartLoadDuration.Observe(time.Since(loadStart).Seconds())
 	if err != nil {
 		w.ec.append(j.repo.ChartRepositoryID, fmt.Errorf("error loading chart %s: %w", u, err))
 		w.logger.Warn().
@@ -116,47 +145,70 @@ func (w *worker) handleJob(j *job) error {
 			Str("version", j.chartVersion.Metadata.Version).
 			Str("url", u).
 			Msg("Chart load failed")
+		w.publishEvent(j, EventChartLoadFailed, err.Error())
 		return nil
 	}
+	if j.chartVersion.Digest == "" {
+		w.rememberFreshness(u)
+	}
 	md := chart.Metadata
 
+	// Verify chart provenance when the repository has a keyring configured.
+	// A chart version that simply wasn't published with a .prov file is
+	// unsigned, not failed, and shouldn't be treated as tampered with.
+	verification := &provenanceVerification{status: hub.VerificationStatusUnsigned}
+	if j.repo.Keyring != "" && len(provData) > 0 {
+		verification, err = w.verifyProvenance(archiveData, provData, j.repo.Keyring)
+		if err != nil {
+			w.ec.append(j.repo.ChartRepositoryID, fmt.Errorf("error verifying provenance for %s: %w", u, err))
+			w.logger.Warn().
+				Str("repo", j.repo.Name).
+				Str("chart", md.Name).
+				Str("version", md.Version).
+				Str("url", u).
+				Msg("Provenance verification failed")
+			verification = &provenanceVerification{status: hub.VerificationStatusFailed}
+			if j.repo.VerifyStrict {
+				return nil
+			}
+		}
+	}
+
 	// Store chart logo when available if requested
 	var logoURL, logoImageID string
 	if j.downloadLogo {
 		if md.Icon != "" {
 			logoURL = md.Icon
-			data, err := w.downloadImage(md.Icon)
+			logoImageID, err = w.processLogo(md.Icon)
 			if err != nil {
-				w.ec.append(j.repo.ChartRepositoryID, fmt.Errorf("error dowloading logo %s: %w", md.Icon, err))
-				w.logger.Debug().Err(err).Str("url", md.Icon).Msg("Image download failed")
-			} else {
-				logoImageID, err = w.imageStore.SaveImage(w.ctx, data)
-				if err != nil && !errors.Is(err, image.ErrFormat) {
-					w.logger.Warn().Err(err).Str("url", md.Icon).Msg("Save image failed")
-				}
+				w.ec.append(j.repo.ChartRepositoryID, fmt.Errorf("error processing logo %s: %w", md.Icon, err))
+				w.logger.Debug().Err(err).Str("url", md.Icon).Msg("Image processing failed")
 			}
 		}
 	}
 
 	// Prepare hub package to be registered
 	p := &hub.Package{
-		Kind:            hub.Chart,
-		Name:            md.Name,
-		Description:     md.Description,
-		HomeURL:         md.Home,
-		LogoURL:         logoURL,
-		LogoImageID:     logoImageID,
-		Keywords:        md.Keywords,
-		Deprecated:      md.Deprecated,
-		Version:         md.Version,
-		AppVersion:      md.AppVersion,
-		Digest:          j.chartVersion.Digest,
-		ChartRepository: j.repo,
+		Kind:               hub.Chart,
+		Name:               md.Name,
+		Description:        md.Description,
+		HomeURL:            md.Home,
+		LogoURL:            logoURL,
+		LogoImageID:        logoImageID,
+		Keywords:           md.Keywords,
+		Deprecated:         md.Deprecated,
+		Version:            md.Version,
+		AppVersion:         md.AppVersion,
+		Digest:             j.chartVersion.Digest,
+		ChartRepository:    j.repo,
+		VerificationStatus: verification.status,
+		SignKeyID:          verification.signKeyID,
 	}
 	readme := getFile(chart, "README.md")
 	if readme != nil {
 		p.Readme = string(readme.Data)
 	}
+	enrichPackage(p, chart)
 	var maintainers []*hub.Maintainer
 	for _, entry := range md.Maintainers {
 		if entry.Email != "" {
@@ -177,38 +229,98 @@ func (w *worker) handleJob(j *job) error {
 			j.repo.ChartRepositoryID,
 			fmt.Errorf("error registering package %s version %s: %w", p.Name, p.Version, err),
 		)
+		return err
+	}
+	if existing == nil {
+		w.publishEvent(j, EventChartVersionAdded, "")
+	} else {
+		w.publishEvent(j, EventChartVersionUpdated, "")
+		if md.Deprecated && !existing.Deprecated {
+			w.publishEvent(j, EventChartDeprecated, "")
+		}
+		if logoImageID != existing.LogoImageID {
+			w.publishEvent(j, EventLogoChanged, "")
+		}
+	}
+	return nil
+}
+
+// loadChart loads a chart located at the url provided, dispatching to the
+// ChartFetcher appropriate for its backend (classic HTTP repo, OCI registry
+// or a remote Artifact Hub-style API). The provenance file is only requested
+// when the repository has a keyring configured, since it's otherwise never
+// used and would just double the request volume against the origin.
+func (w *worker) loadChart(u string, needProv bool) (c *chart.Chart, archive []byte, prov []byte, err error) {
+	fetcher, err := fetcherFor(u, w.httpClient)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error selecting chart fetcher for %s: %w", u, err)
 	}
-	return err
+	return fetcher.FetchChart(w.ctx, u, needProv)
+}
+
+// provenanceVerification holds the outcome of verifying a chart archive
+// against its provenance file.
+type provenanceVerification struct {
+	status    hub.VerificationStatus
+	signKeyID string
 }
 
-// loadChart loads a chart from a remote archive located at the url provided.
-func (w *worker) loadChart(u string) (*chart.Chart, error) {
-	resp, err := w.httpClient.Get(u)
+// verifyProvenance verifies the chart archive bytes against its provenance
+// data using the keyring provided, following Helm's DownloadTo/Verify model.
+// Callers are expected to only invoke this once they know a provenance file
+// is actually available; an unsigned chart version is not a verification
+// failure.
+func (w *worker) verifyProvenance(archive, prov []byte, keyring string) (*provenanceVerification, error) {
+	tmp, err := ioutil.TempFile("", "chart-*.tgz")
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusOK {
-		chart, err := loader.LoadArchive(resp.Body)
-		if err != nil {
-			return nil, err
-		}
-		return chart, nil
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(archive); err != nil {
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("unexpected status code received: %d", resp.StatusCode)
-}
 
-// downloadImage downloads the image located at the url provided.
-func (w *worker) downloadImage(u string) ([]byte, error) {
-	resp, err := w.httpClient.Get(u)
+	signatory, err := provenance.NewFromKeyring(keyring, "")
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error loading keyring: %w", err)
+	}
+	verification, err := signatory.Verify(tmp.Name(), string(prov))
+	if err != nil {
+		return nil, fmt.Errorf("error verifying signature: %w", err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusOK {
-		return ioutil.ReadAll(resp.Body)
+
+	signKeyID := ""
+	if verification.SignedBy != nil && verification.SignedBy.PrimaryKey != nil {
+		signKeyID = verification.SignedBy.PrimaryKey.KeyIdString()
+	}
+	return &provenanceVerification{
+		status:    hub.VerificationStatusVerified,
+		signKeyID: signKeyID,
+	}, nil
+}
+
+// publishEvent delivers an event about the chart version being processed to
+// the globally configured sinks plus any sink configured on the repository
+// itself (eg. a per-repo webhook).
+func (w *worker) publishEvent(j *job, t EventType, errMsg string) {
+	e := &Event{
+		Type:      t,
+		Timestamp: time.Now(),
+		Repo:      j.repo.Name,
+		Chart:     j.chartVersion.Metadata.Name,
+		Version:   j.chartVersion.Metadata.Version,
+		Digest:    j.chartVersion.Digest,
+		Error:     errMsg,
 	}
-	return nil, fmt.Errorf("unexpected status code received: %d", resp.StatusCode)
+	repoSinks := sinksFor(j.repo, w.httpClient)
+	sinks := make([]EventSink, 0, len(w.events.sinks)+len(repoSinks))
+	sinks = append(sinks, w.events.sinks...)
+	sinks = append(sinks, repoSinks...)
+	bus := newEventBus(sinks...)
+	bus.publish(w.ctx, e)
 }
 
 // getFile returns the file requested from the provided chart.